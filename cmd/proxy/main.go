@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robbilie/oauth-client-credentials-proxy/logger"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/auth"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/config"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/proxy"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		logger.NewLogger("info").Fatalw("Couldn't load configuration", "err", err)
+		return
+	}
+
+	loggerInstance := logger.NewLogger(cfg.LogLevel)
+
+	handler, err := newHandler(loggerInstance, cfg)
+	if err != nil {
+		loggerInstance.Fatalw("Couldn't initialize server", "err", err)
+		return
+	}
+
+	httpServer := &http.Server{
+		Addr:    cfg.ListenAddress,
+		Handler: handler,
+	}
+
+	go func() {
+		loggerInstance.Infow("Starting server", "addr", cfg.ListenAddress)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			loggerInstance.Fatalw("Error running server", "err", err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	loggerInstance.Infow("Shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		loggerInstance.Errorw("Error shutting down server", "err", err)
+	}
+}
+
+// newHandler builds a proxy.Router serving every configured route, each with
+// its own token cache, client auth and, where enabled, inbound JWT verifier,
+// plus a /metrics endpoint exposing the package's Prometheus collectors.
+func newHandler(loggerInstance logger.Logger, cfg *config.Config) (http.Handler, error) {
+	router := proxy.NewRouter(loggerInstance)
+
+	for _, route := range cfg.Routes {
+		handler, err := newRouteHandler(loggerInstance, cfg, route)
+		if err != nil {
+			return nil, err
+		}
+		router.Add(proxy.RouteMatch{PathPrefix: route.PathPrefix, Host: route.Host}, handler)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", router)
+
+	return mux, nil
+}
+
+func newRouteHandler(loggerInstance logger.Logger, cfg *config.Config, route config.Route) (http.Handler, error) {
+	backend, err := auth.NewLRUTokenCacheBackend(cfg.TokenCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	cache := auth.NewTokenCache(backend, cfg.TokenCacheRefreshSkew)
+
+	builder, err := auth.NewBuilder(loggerInstance, route, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var inboundVerifier *auth.InboundVerifier
+	if route.InboundVerificationMode == config.InboundVerificationJWT {
+		inboundVerifier, err = auth.NewInboundVerifier(builder.HTTPContext(), route.OIDCIssuer, route.OIDCAudience, route.OIDCSubjectClaim, route.OIDCExpectedAzp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return proxy.New(loggerInstance, route, builder, inboundVerifier)
+}
@@ -0,0 +1,201 @@
+// Package auth builds outbound OAuth2 token sources for each supported client
+// authentication method and validates inbound subject tokens.
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/robbilie/oauth-client-credentials-proxy/logger"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// jwtAssertionLifetime is how long a signed client assertion is valid for;
+// it only needs to outlive the single token request it is used for.
+const jwtAssertionLifetime = 60 * time.Second
+
+// jwtSigner signs RFC 7523 client assertions with a private key loaded from disk.
+type jwtSigner struct {
+	key    interface{}
+	method jwt.SigningMethod
+}
+
+func loadJwtSigner(path string) (*jwtSigner, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return &jwtSigner{key: key, method: jwt.SigningMethodRS256}, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return &jwtSigner{key: key, method: jwt.SigningMethodES256}, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key in %s: %w", path, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &jwtSigner{key: k, method: jwt.SigningMethodRS256}, nil
+	case *ecdsa.PrivateKey:
+		return &jwtSigner{key: k, method: jwt.SigningMethodES256}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T in %s", key, path)
+	}
+}
+
+// sign builds a short-lived client assertion with iss=sub=clientID and the given audience.
+func (j *jwtSigner) sign(clientID string, audience string) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(jwtAssertionLifetime)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        uuid.NewString(),
+	}
+	return jwt.NewWithClaims(j.method, claims).SignedString(j.key)
+}
+
+// dynamicTokenSource rebuilds the clientcredentials.Config on every Token() call so that
+// auth methods needing per-request material (e.g. a freshly signed client assertion) stay valid.
+type dynamicTokenSource struct {
+	ctx   context.Context
+	build func() (*clientcredentials.Config, error)
+}
+
+func (d *dynamicTokenSource) Token() (*oauth2.Token, error) {
+	conf, err := d.build()
+	if err != nil {
+		return nil, err
+	}
+	return conf.TokenSource(d.ctx).Token()
+}
+
+// Builder builds oauth2.TokenSources for a single route's configured client
+// authentication method, caching the route's system token and every
+// personalized (token-exchange) token it hands out.
+type Builder struct {
+	ctx    context.Context
+	route  config.Route
+	signer *jwtSigner
+	cache  *TokenCache
+
+	system oauth2.TokenSource
+}
+
+// NewBuilder validates the route's client auth material, builds the mTLS HTTP
+// context when needed, and returns a Builder ready to mint token sources.
+func NewBuilder(log logger.Logger, route config.Route, cache *TokenCache) (*Builder, error) {
+	ctx := context.Background()
+
+	if route.CertPath != "" && route.KeyPath != "" {
+		passphraseSource := routePassphraseSource(route.KeyPassphrase, route.KeyPassphraseFile, route.KeyPassphraseHelper)
+		httpClient, err := tlsHTTPClient(log, route.CertPath, route.KeyPath, route.CACertPath, passphraseSource)
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+
+	var signer *jwtSigner
+	if route.ClientAuthMethod == config.ClientAuthPrivateKeyJWT {
+		var err error
+		signer, err = loadJwtSigner(route.ClientAssertionKeyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b := &Builder{ctx: ctx, route: route, signer: signer, cache: cache}
+	b.system = cache.TokenSource(TokenCacheKey{AuthMode: "system", Scope: route.Scope}, &dynamicTokenSource{
+		ctx:   ctx,
+		build: func() (*clientcredentials.Config, error) { return b.clientCredentialsConfig(nil) },
+	})
+	return b, nil
+}
+
+// HTTPContext returns the context token sources should be requested with; it
+// carries the route's mTLS HTTP client, if any.
+func (b *Builder) HTTPContext() context.Context {
+	return b.ctx
+}
+
+// SystemTokenSource returns the route's cached client-credentials token source.
+func (b *Builder) SystemTokenSource() oauth2.TokenSource {
+	return b.system
+}
+
+// PersonalizedTokenSource returns a cached token source for a token-exchange
+// request on behalf of subject, merging extraParams (grant_type, actor_token, ...)
+// into the client-credentials request. Building the config (and, for
+// private_key_jwt, signing the client assertion) is deferred to the cache's
+// miss path so a cache hit does no crypto.
+func (b *Builder) PersonalizedTokenSource(authMode config.AuthMode, subject string, extraParams url.Values) (oauth2.TokenSource, error) {
+	key := TokenCacheKey{AuthMode: string(authMode), Subject: subject, Scope: b.route.Scope}
+	return b.cache.TokenSource(key, &dynamicTokenSource{
+		ctx:   b.ctx,
+		build: func() (*clientcredentials.Config, error) { return b.clientCredentialsConfig(extraParams) },
+	}), nil
+}
+
+// clientCredentialsConfig builds a clientcredentials.Config for the route's
+// configured ClientAuthMethod, merging in any additional EndpointParams.
+func (b *Builder) clientCredentialsConfig(extraParams url.Values) (*clientcredentials.Config, error) {
+	params := url.Values{}
+	for k, v := range extraParams {
+		params[k] = v
+	}
+
+	conf := &clientcredentials.Config{
+		ClientID:       b.route.ClientID,
+		Scopes:         strings.Split(b.route.Scope, ","),
+		TokenURL:       b.route.TokenURL,
+		EndpointParams: params,
+	}
+
+	switch b.route.ClientAuthMethod {
+	case config.ClientAuthSecretPost:
+		conf.ClientSecret = b.route.ClientSecret
+		conf.AuthStyle = oauth2.AuthStyleInParams
+	case config.ClientAuthPrivateKeyJWT:
+		assertion, err := b.signer.sign(b.route.ClientID, b.route.TokenURL)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		params.Set("client_assertion", assertion)
+		// client_assertion is the sole credential; suppress oauth2's default
+		// AuthStyleInHeader probe so no Basic header with an empty secret is sent.
+		conf.AuthStyle = oauth2.AuthStyleInParams
+	case config.ClientAuthTLSClientAuth:
+		// client authenticates via the mTLS certificate already attached to ctx;
+		// suppress the Basic header oauth2 would otherwise probe for.
+		conf.AuthStyle = oauth2.AuthStyleInParams
+	default: // config.ClientAuthSecretBasic
+		conf.ClientSecret = b.route.ClientSecret
+		conf.AuthStyle = oauth2.AuthStyleInHeader
+	}
+
+	return conf, nil
+}
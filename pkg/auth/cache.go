@@ -0,0 +1,139 @@
+package auth
+
+import (
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+	"time"
+)
+
+var (
+	cacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oauth_tokens_cache_hits_total",
+		Help: "Total number of token cache hits",
+	})
+	cacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oauth_tokens_cache_misses_total",
+		Help: "Total number of token cache misses",
+	})
+	tokenFetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "oauth_token_fetch_duration_seconds",
+		Help:    "Time spent fetching a token from the token endpoint on a cache miss",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		cacheHitsTotal,
+		cacheMissesTotal,
+		tokenFetchDuration,
+	)
+}
+
+// TokenCacheKey identifies a cached token. Tokens are never shared across auth
+// modes, subjects or scopes, since each combination can carry different
+// audience/claims.
+type TokenCacheKey struct {
+	AuthMode string
+	Subject  string
+	Scope    string
+}
+
+func (k TokenCacheKey) String() string {
+	return k.AuthMode + "|" + k.Subject + "|" + k.Scope
+}
+
+// TokenCacheBackend stores tokens by key. The default backend is an in-memory
+// LRU; a Redis-backed implementation can be swapped in for multi-replica
+// deployments.
+type TokenCacheBackend interface {
+	Get(key string) (*oauth2.Token, bool)
+	Set(key string, token *oauth2.Token)
+}
+
+type lruTokenCacheBackend struct {
+	cache *lru.Cache[string, *oauth2.Token]
+}
+
+// NewLRUTokenCacheBackend returns the default in-memory TokenCacheBackend.
+func NewLRUTokenCacheBackend(size int) (TokenCacheBackend, error) {
+	cache, err := lru.New[string, *oauth2.Token](size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruTokenCacheBackend{cache: cache}, nil
+}
+
+func (b *lruTokenCacheBackend) Get(key string) (*oauth2.Token, bool) {
+	return b.cache.Get(key)
+}
+
+func (b *lruTokenCacheBackend) Set(key string, token *oauth2.Token) {
+	b.cache.Add(key, token)
+}
+
+// TokenCache wraps oauth2.TokenSources with ReuseTokenSource-like semantics,
+// shared across requests and keyed by TokenCacheKey. Concurrent misses for the
+// same key are coalesced via singleflight so a burst of requests for the same
+// subject only hits the token endpoint once.
+type TokenCache struct {
+	backend     TokenCacheBackend
+	refreshSkew time.Duration
+	inflight    singleflight.Group
+}
+
+func NewTokenCache(backend TokenCacheBackend, refreshSkew time.Duration) *TokenCache {
+	return &TokenCache{backend: backend, refreshSkew: refreshSkew}
+}
+
+func (c *TokenCache) valid(token *oauth2.Token) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	if token.Expiry.IsZero() {
+		return true
+	}
+	return time.Until(token.Expiry) > c.refreshSkew
+}
+
+// TokenSource returns an oauth2.TokenSource backed by the cache for the given
+// key, falling back to underlying on a miss or expiry.
+func (c *TokenCache) TokenSource(key TokenCacheKey, underlying oauth2.TokenSource) oauth2.TokenSource {
+	return &cachedTokenSource{cache: c, key: key.String(), underlying: underlying}
+}
+
+type cachedTokenSource struct {
+	cache      *TokenCache
+	key        string
+	underlying oauth2.TokenSource
+}
+
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	if token, ok := s.cache.backend.Get(s.key); ok && s.cache.valid(token) {
+		cacheHitsTotal.Inc()
+		return token, nil
+	}
+	cacheMissesTotal.Inc()
+
+	v, err, _ := s.cache.inflight.Do(s.key, func() (interface{}, error) {
+		if token, ok := s.cache.backend.Get(s.key); ok && s.cache.valid(token) {
+			return token, nil
+		}
+
+		start := time.Now()
+		token, err := s.underlying.Token()
+		tokenFetchDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			return nil, err
+		}
+
+		s.cache.backend.Set(s.key, token)
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*oauth2.Token), nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// ValidationTime records how long inbound subject-token validation takes. It
+// keeps the metric name earlier versions of the proxy exported it under and is
+// served on the proxy's /metrics endpoint.
+var ValidationTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "nginx_subrequest_auth_jwt_token_validation_time_seconds",
+	Help:    "Number of seconds spent validating token",
+	Buckets: prometheus.ExponentialBuckets(100*time.Nanosecond.Seconds(), 3, 6),
+})
+
+func init() {
+	prometheus.MustRegister(ValidationTime)
+}
+
+// InboundVerifier validates inbound subject tokens against an OIDC issuer and
+// extracts the configured subject claim from them.
+type InboundVerifier struct {
+	verifier     *oidc.IDTokenVerifier
+	subjectClaim string
+	expectedAzp  string
+}
+
+// NewInboundVerifier fetches the issuer's discovery document and JWKS (go-oidc
+// caches and refreshes the keyset internally) and returns a verifier for audience.
+func NewInboundVerifier(ctx context.Context, issuer string, audience string, subjectClaim string, expectedAzp string) (*InboundVerifier, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &InboundVerifier{
+		verifier:     provider.Verifier(&oidc.Config{ClientID: audience}),
+		subjectClaim: subjectClaim,
+		expectedAzp:  expectedAzp,
+	}, nil
+}
+
+// nbfLeeway allows for minor clock skew between this proxy and the issuer
+// when checking the not-before claim.
+const nbfLeeway = 1 * time.Minute
+
+// Verify validates rawToken's signature, iss, aud and exp via go-oidc, then
+// additionally checks nbf (which go-oidc's Verify does not enforce) and
+// returns the configured subject claim.
+func (v *InboundVerifier) Verify(ctx context.Context, rawToken string) (string, error) {
+	start := time.Now()
+	idToken, err := v.verifier.Verify(ctx, rawToken)
+	ValidationTime.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", fmt.Errorf("verifying subject token: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", fmt.Errorf("decoding subject token claims: %w", err)
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if notBefore := time.Unix(int64(nbf), 0); time.Now().Add(nbfLeeway).Before(notBefore) {
+			return "", fmt.Errorf("subject token not valid yet (nbf %s)", notBefore)
+		}
+	}
+
+	if v.expectedAzp != "" {
+		azp, _ := claims["azp"].(string)
+		if azp != v.expectedAzp {
+			return "", fmt.Errorf("unexpected azp claim %q", azp)
+		}
+	}
+
+	subject, ok := claims[v.subjectClaim].(string)
+	if !ok || subject == "" {
+		return "", fmt.Errorf("claim %q not present in subject token", v.subjectClaim)
+	}
+
+	return subject, nil
+}
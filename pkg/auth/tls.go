@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/robbilie/oauth-client-credentials-proxy/logger"
+	"github.com/youmark/pkcs8"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// tlsHTTPClient builds an *http.Client presenting the client certificate at
+// certPath/keyPath, decrypting the key first if it is encrypted.
+func tlsHTTPClient(log logger.Logger, certPath string, keyPath string, caCertPath string, passphraseSource passphraseSource) (*http.Client, error) {
+	cert, err := loadClientCertificate(log, certPath, keyPath, passphraseSource)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// loadClientCertificate loads the client key pair at certPath/keyPath,
+// transparently decrypting the private key if its PEM block is encrypted.
+func loadClientCertificate(log logger.Logger, certPath string, keyPath string, passphraseSource passphraseSource) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("no PEM block found in %s", keyPath)
+	}
+
+	if !isEncryptedPEMBlock(block) {
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	passphrase, source, err := passphraseSource(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if passphrase == "" {
+		return tls.Certificate{}, fmt.Errorf("key %s is encrypted but no passphrase was configured (KEY_PASSPHRASE, KEY_PASSPHRASE_FILE or KEY_PASSPHRASE_HELPER)", keyPath)
+	}
+	log.Debugw("Decrypting client key", "path", keyPath, "passphraseSource", source)
+
+	decryptedKeyPEM, err := decryptPEMBlock(block, passphrase)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decrypting %s: %w", keyPath, err)
+	}
+
+	return tls.X509KeyPair(certPEM, decryptedKeyPEM)
+}
+
+// isEncryptedPEMBlock reports whether block is a legacy "Proc-Type: 4,ENCRYPTED"
+// PEM block or a PKCS#8 "ENCRYPTED PRIVATE KEY" block.
+func isEncryptedPEMBlock(block *pem.Block) bool {
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return true
+	}
+	return x509.IsEncryptedPEMBlock(block) //nolint:staticcheck // the PKCS#1/RSA legacy format has no replacement yet
+}
+
+// decryptPEMBlock decrypts block with passphrase and re-encodes the result as
+// an unencrypted PEM block, handling both the legacy "Proc-Type: 4,ENCRYPTED"
+// format and PKCS#8 "ENCRYPTED PRIVATE KEY".
+func decryptPEMBlock(block *pem.Block, passphrase string) ([]byte, error) {
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	der, err := x509.DecryptPEMBlock(block, []byte(passphrase)) //nolint:staticcheck // no stdlib replacement for PKCS#1 encrypted PEM
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// passphraseSource resolves the passphrase for the private key at keyPath,
+// returning the name of the source it came from for logging.
+type passphraseSource func(keyPath string) (passphrase string, source string, err error)
+
+// routePassphraseSource resolves a route's configured passphrase in priority
+// order: KEY_PASSPHRASE, then KEY_PASSPHRASE_FILE, then KEY_PASSPHRASE_HELPER
+// (mirroring the git-credential helper pattern: the helper is executed with
+// the key path as argv and the passphrase is read from its stdout).
+func routePassphraseSource(passphrase string, passphraseFile string, passphraseHelper string) passphraseSource {
+	return func(keyPath string) (string, string, error) {
+		if passphrase != "" {
+			return passphrase, "KEY_PASSPHRASE", nil
+		}
+		if passphraseFile != "" {
+			raw, err := ioutil.ReadFile(passphraseFile)
+			if err != nil {
+				return "", "", fmt.Errorf("reading KEY_PASSPHRASE_FILE: %w", err)
+			}
+			return strings.TrimRight(string(raw), "\r\n"), "KEY_PASSPHRASE_FILE", nil
+		}
+		if passphraseHelper != "" {
+			out, err := exec.Command(passphraseHelper, keyPath).Output()
+			if err != nil {
+				return "", "", fmt.Errorf("running KEY_PASSPHRASE_HELPER: %w", err)
+			}
+			return strings.TrimRight(string(out), "\r\n"), "KEY_PASSPHRASE_HELPER", nil
+		}
+		return "", "none", nil
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoutePassphraseSource(t *testing.T) {
+	dir := t.TempDir()
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		passphrase     string
+		passphraseFile string
+		wantPassphrase string
+		wantSource     string
+	}{
+		{name: "env takes priority", passphrase: "from-env", passphraseFile: passphraseFile, wantPassphrase: "from-env", wantSource: "KEY_PASSPHRASE"},
+		{name: "file used when env unset", passphraseFile: passphraseFile, wantPassphrase: "from-file", wantSource: "KEY_PASSPHRASE_FILE"},
+		{name: "none configured", wantPassphrase: "", wantSource: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := routePassphraseSource(tt.passphrase, tt.passphraseFile, "")
+			passphrase, got, err := source("/irrelevant/key/path")
+			if err != nil {
+				t.Fatalf("source() error = %v", err)
+			}
+			if passphrase != tt.wantPassphrase || got != tt.wantSource {
+				t.Errorf("source() = (%q, %q), want (%q, %q)", passphrase, got, tt.wantPassphrase, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package config loads and validates the proxy's configuration, either from
+// individual environment variables (single-route, backwards compatible with
+// earlier versions of the proxy) or from a YAML/JSON file declaring one or
+// more routes.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuthMode string
+
+const (
+	AuthModeClientCredentials AuthMode = "CLIENT_CREDENTIALS"
+	AuthModeActorToken        AuthMode = "ACTOR_TOKEN"
+)
+
+type ClientAuthMethod string
+
+const (
+	ClientAuthSecretBasic   ClientAuthMethod = "client_secret_basic"
+	ClientAuthSecretPost    ClientAuthMethod = "client_secret_post"
+	ClientAuthPrivateKeyJWT ClientAuthMethod = "private_key_jwt"
+	ClientAuthTLSClientAuth ClientAuthMethod = "tls_client_auth"
+)
+
+type InboundVerificationMode string
+
+const (
+	InboundVerificationNone InboundVerificationMode = "none"
+	InboundVerificationJWT  InboundVerificationMode = "jwt"
+)
+
+// Route describes a single upstream and the OAuth client used to authenticate to it.
+type Route struct {
+	// PathPrefix and Host select which inbound requests this route handles.
+	// A route with neither set matches any request that reaches it and acts
+	// as the catch-all/default route.
+	PathPrefix string `yaml:"path_prefix" json:"path_prefix"`
+	Host       string `yaml:"host" json:"host"`
+
+	Upstream                string                  `yaml:"upstream" json:"upstream"`
+	TokenURL                string                  `yaml:"token_url" json:"token_url"`
+	ClientID                string                  `yaml:"client_id" json:"client_id"`
+	ClientSecret            string                  `yaml:"client_secret" json:"client_secret"`
+	Scope                   string                  `yaml:"scope" json:"scope"`
+	CertPath                string                  `yaml:"cert_path" json:"cert_path"`
+	KeyPath                 string                  `yaml:"key_path" json:"key_path"`
+	CACertPath              string                  `yaml:"cacert_path" json:"cacert_path"`
+	KeyPassphrase           string                  `yaml:"key_passphrase" json:"key_passphrase"`
+	KeyPassphraseFile       string                  `yaml:"key_passphrase_file" json:"key_passphrase_file"`
+	KeyPassphraseHelper     string                  `yaml:"key_passphrase_helper" json:"key_passphrase_helper"`
+	AuthMode                AuthMode                `yaml:"auth_mode" json:"auth_mode"`
+	SubjectField            string                  `yaml:"subject_field" json:"subject_field"`
+	ClientAuthMethod        ClientAuthMethod        `yaml:"client_auth_method" json:"client_auth_method"`
+	ClientAssertionKeyPath  string                  `yaml:"client_assertion_key_path" json:"client_assertion_key_path"`
+	InboundVerificationMode InboundVerificationMode `yaml:"inbound_verification_mode" json:"inbound_verification_mode"`
+	OIDCIssuer              string                  `yaml:"oidc_issuer" json:"oidc_issuer"`
+	OIDCAudience            string                  `yaml:"oidc_audience" json:"oidc_audience"`
+	OIDCSubjectClaim        string                  `yaml:"oidc_subject_claim" json:"oidc_subject_claim"`
+	OIDCExpectedAzp         string                  `yaml:"oidc_expected_azp" json:"oidc_expected_azp"`
+}
+
+// Config is the fully resolved proxy configuration.
+type Config struct {
+	ListenAddress         string        `yaml:"-" json:"-"`
+	LogLevel              string        `yaml:"-" json:"-"`
+	TokenCacheSize        int           `yaml:"token_cache_size" json:"token_cache_size"`
+	TokenCacheRefreshSkew time.Duration `yaml:"-" json:"-"`
+	Routes                []Route       `yaml:"routes" json:"routes"`
+}
+
+// fileConfig mirrors Config for the parts that come from a config file; the
+// listen address and log level stay environment-only since they are process
+// concerns, not routing concerns.
+type fileConfig struct {
+	TokenCacheSize        int     `yaml:"token_cache_size" json:"token_cache_size"`
+	TokenCacheRefreshSkew string  `yaml:"token_cache_refresh_skew" json:"token_cache_refresh_skew"`
+	Routes                []Route `yaml:"routes" json:"routes"`
+}
+
+// Load builds a Config from CONFIG_FILE if set, otherwise from the legacy
+// single-route environment variables.
+func Load() (*Config, error) {
+	cfg := &Config{
+		ListenAddress:         ":" + getEnv("PORT", "8080"),
+		LogLevel:              getEnv("LOG_LEVEL", "info"),
+		TokenCacheSize:        getEnvInt("TOKEN_CACHE_SIZE", 1024),
+		TokenCacheRefreshSkew: getEnvDuration("TOKEN_CACHE_REFRESH_SKEW", 30*time.Second),
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Routes = file.Routes
+		if file.TokenCacheSize != 0 {
+			cfg.TokenCacheSize = file.TokenCacheSize
+		}
+		if file.TokenCacheRefreshSkew != "" {
+			skew, err := time.ParseDuration(file.TokenCacheRefreshSkew)
+			if err != nil {
+				return nil, fmt.Errorf("parsing token_cache_refresh_skew: %w", err)
+			}
+			cfg.TokenCacheRefreshSkew = skew
+		}
+	} else {
+		cfg.Routes = []Route{routeFromEnv()}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func routeFromEnv() Route {
+	return Route{
+		Upstream:                os.Getenv("UPSTREAM"),
+		TokenURL:                os.Getenv("TOKEN_URL"),
+		ClientID:                os.Getenv("CLIENT_ID"),
+		ClientSecret:            getEnv("CLIENT_SECRET", ""),
+		Scope:                   getEnv("SCOPE", ""),
+		CertPath:                os.Getenv("CERT_PATH"),
+		KeyPath:                 os.Getenv("KEY_PATH"),
+		CACertPath:              os.Getenv("CACERT_PATH"),
+		KeyPassphrase:           os.Getenv("KEY_PASSPHRASE"),
+		KeyPassphraseFile:       os.Getenv("KEY_PASSPHRASE_FILE"),
+		KeyPassphraseHelper:     os.Getenv("KEY_PASSPHRASE_HELPER"),
+		AuthMode:                AuthMode(getEnv("TOKEN_EXCHANGE_AUTH_MODE", string(AuthModeClientCredentials))),
+		SubjectField:            getEnv("TOKEN_EXCHANGE_SUBJECT_FIELD", "subject"),
+		ClientAuthMethod:        ClientAuthMethod(getEnv("CLIENT_AUTH_METHOD", string(ClientAuthSecretBasic))),
+		ClientAssertionKeyPath:  os.Getenv("CLIENT_ASSERTION_KEY_PATH"),
+		InboundVerificationMode: InboundVerificationMode(getEnv("INBOUND_VERIFICATION_MODE", string(InboundVerificationNone))),
+		OIDCIssuer:              os.Getenv("OIDC_ISSUER"),
+		OIDCAudience:            os.Getenv("OIDC_AUDIENCE"),
+		OIDCSubjectClaim:        getEnv("OIDC_SUBJECT_CLAIM", "sub"),
+		OIDCExpectedAzp:         os.Getenv("OIDC_EXPECTED_AZP"),
+	}
+}
+
+func loadFile(path string) (*fileConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", path, err)
+	}
+
+	file := &fileConfig{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(raw, file)
+	case ".yaml", ".yml", "":
+		err = yaml.Unmarshal(raw, file)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// Validate checks that every route carries enough information to build a
+// working token source and reverse proxy.
+func (c *Config) Validate() error {
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("no routes configured")
+	}
+	for i, route := range c.Routes {
+		if route.Upstream == "" {
+			return fmt.Errorf("route %d: upstream is required", i)
+		}
+		if route.TokenURL == "" {
+			return fmt.Errorf("route %d: token_url is required", i)
+		}
+		if route.ClientID == "" {
+			return fmt.Errorf("route %d: client_id is required", i)
+		}
+		switch route.ClientAuthMethod {
+		case ClientAuthPrivateKeyJWT:
+			if route.ClientAssertionKeyPath == "" {
+				return fmt.Errorf("route %d: client_assertion_key_path is required for client auth method %s", i, ClientAuthPrivateKeyJWT)
+			}
+		case ClientAuthTLSClientAuth:
+			if route.CertPath == "" || route.KeyPath == "" {
+				return fmt.Errorf("route %d: cert_path and key_path are required for client auth method %s", i, ClientAuthTLSClientAuth)
+			}
+		}
+		if route.InboundVerificationMode == InboundVerificationJWT {
+			if route.OIDCIssuer == "" {
+				return fmt.Errorf("route %d: oidc_issuer is required when inbound_verification_mode is %s", i, InboundVerificationJWT)
+			}
+			if route.OIDCAudience == "" {
+				return fmt.Errorf("route %d: oidc_audience is required when inbound_verification_mode is %s", i, InboundVerificationJWT)
+			}
+		}
+	}
+	return nil
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,148 @@
+// Package proxy implements the reverse-proxying HTTP handler that exchanges
+// or attaches OAuth tokens before forwarding requests upstream.
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robbilie/oauth-client-credentials-proxy/logger"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/auth"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/config"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+)
+
+var requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_requests_total",
+	Help: "Total number of http requests handled",
+}, []string{"status"})
+
+func init() {
+	requestsTotal.WithLabelValues("200")
+	requestsTotal.WithLabelValues("401")
+	requestsTotal.WithLabelValues("404")
+	requestsTotal.WithLabelValues("405")
+	requestsTotal.WithLabelValues("500")
+
+	prometheus.MustRegister(requestsTotal)
+}
+
+// Server proxies requests to a single route's upstream, attaching a
+// client-credentials token or, for subject-bearing requests, a token-exchange
+// token for the subject.
+type Server struct {
+	upstream      *url.URL
+	reverseProxy  *httputil.ReverseProxy
+	logger        logger.Logger
+	route         config.Route
+	builder       *auth.Builder
+	inboundVerify *auth.InboundVerifier
+}
+
+// statusRecordingResponseWriter wraps a ResponseWriter to capture the status
+// code the upstream actually responded with, since httputil.ReverseProxy
+// writes straight through to the original ResponseWriter.
+type statusRecordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// New builds a Server for route. builder must already be wired to route's
+// client auth method; inboundVerifier may be nil when the route trusts the
+// inbound subject header as-is.
+func New(logger logger.Logger, route config.Route, builder *auth.Builder, inboundVerifier *auth.InboundVerifier) (*Server, error) {
+	u, err := url.Parse(route.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		upstream:      u,
+		reverseProxy:  httputil.NewSingleHostReverseProxy(u),
+		logger:        logger,
+		route:         route,
+		builder:       builder,
+		inboundVerify: inboundVerifier,
+	}, nil
+}
+
+func (s *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// Update the headers to allow for SSL redirection
+	req.URL.Host = s.upstream.Host
+	req.URL.Scheme = s.upstream.Scheme
+	req.Host = s.upstream.Host
+
+	subjectHeader := "x-" + s.route.SubjectField
+	if req.Header.Get(subjectHeader) != "" {
+		subject := req.Header.Get(subjectHeader)
+
+		if s.inboundVerify != nil {
+			verifiedSubject, err := s.inboundVerify.Verify(s.builder.HTTPContext(), subject)
+			if err != nil {
+				s.logger.Errorw("Error validating subject token", err)
+				requestsTotal.WithLabelValues("401").Inc()
+				res.WriteHeader(401)
+				return
+			}
+			subject = verifiedSubject
+		}
+
+		endpointParams := url.Values{
+			"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+			"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+			s.route.SubjectField:   {subject},
+		}
+
+		if s.route.AuthMode == config.AuthModeActorToken {
+			// fetch system token first to perform exchange
+			token, err := s.builder.SystemTokenSource().Token()
+			if err != nil {
+				s.logger.Errorw("Error getting system token", err)
+				requestsTotal.WithLabelValues("500").Inc()
+				res.WriteHeader(500)
+				return
+			}
+			endpointParams.Set("actor_token_type", "urn:ietf:params:oauth:token-type:access_token")
+			endpointParams.Set("actor_token", token.AccessToken)
+		}
+
+		personalizedTokenSource, err := s.builder.PersonalizedTokenSource(s.route.AuthMode, subject, endpointParams)
+		if err != nil {
+			s.logger.Errorw("Error building the subject token request", err)
+			requestsTotal.WithLabelValues("500").Inc()
+			res.WriteHeader(500)
+			return
+		}
+
+		token, err := personalizedTokenSource.Token()
+		if err != nil {
+			s.logger.Errorw("Error fetching the subject token", err)
+			requestsTotal.WithLabelValues("500").Inc()
+			res.WriteHeader(500)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	} else {
+		// only fetch system token
+		token, err := s.builder.SystemTokenSource().Token()
+		if err != nil {
+			s.logger.Errorw("Error getting client credential token", err)
+			requestsTotal.WithLabelValues("500").Inc()
+			res.WriteHeader(500)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+	// ServeHTTP returns once the upstream response has been copied to res, so
+	// recorder.status holds the upstream's status code afterwards.
+	recorder := &statusRecordingResponseWriter{ResponseWriter: res, status: http.StatusOK}
+	s.reverseProxy.ServeHTTP(recorder, req)
+	requestsTotal.WithLabelValues(strconv.Itoa(recorder.status)).Inc()
+}
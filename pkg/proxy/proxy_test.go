@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/auth"
+	"github.com/robbilie/oauth-client-credentials-proxy/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testLogger is a no-op logger.Logger for tests.
+type testLogger struct{}
+
+func (testLogger) Debugw(msg string, keysAndValues ...interface{}) {}
+func (testLogger) Infow(msg string, keysAndValues ...interface{})  {}
+func (testLogger) Warnw(msg string, keysAndValues ...interface{})  {}
+func (testLogger) Errorw(msg string, keysAndValues ...interface{}) {}
+func (testLogger) Fatalw(msg string, keysAndValues ...interface{}) {}
+
+func newTestServer(t *testing.T, route config.Route) (*Server, func()) {
+	t.Helper()
+
+	cache := auth.NewTokenCache(mustLRUBackend(t), time.Second)
+	builder, err := auth.NewBuilder(testLogger{}, route, cache)
+	if err != nil {
+		t.Fatalf("NewBuilder: %v", err)
+	}
+
+	var verifier *auth.InboundVerifier
+	srv, err := New(testLogger{}, route, builder, verifier)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return srv, func() {}
+}
+
+func mustLRUBackend(t *testing.T) auth.TokenCacheBackend {
+	t.Helper()
+	backend, err := auth.NewLRUTokenCacheBackend(16)
+	if err != nil {
+		t.Fatalf("NewLRUTokenCacheBackend: %v", err)
+	}
+	return backend
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name           string
+		authMode       config.AuthMode
+		subjectHeader  string
+		tokenHandler   http.HandlerFunc
+		wantStatus     int
+		wantAuthHeader string
+	}{
+		{
+			name:     "system token only",
+			authMode: config.AuthModeClientCredentials,
+			tokenHandler: func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"access_token": "system-token",
+					"token_type":   "Bearer",
+					"expires_in":   3600,
+				})
+			},
+			wantStatus:     http.StatusOK,
+			wantAuthHeader: "Bearer system-token",
+		},
+		{
+			name:          "token exchange via subject header",
+			authMode:      config.AuthModeClientCredentials,
+			subjectHeader: "alice",
+			tokenHandler: func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("ParseForm: %v", err)
+				}
+				if r.Form.Get("subject") != "alice" {
+					t.Errorf("subject = %q, want alice", r.Form.Get("subject"))
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"access_token": "exchanged-token",
+					"token_type":   "Bearer",
+					"expires_in":   3600,
+				})
+			},
+			wantStatus:     http.StatusOK,
+			wantAuthHeader: "Bearer exchanged-token",
+		},
+		{
+			name:     "token endpoint failure surfaces as 500",
+			authMode: config.AuthModeClientCredentials,
+			tokenHandler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(tt.tokenHandler)
+			defer tokenServer.Close()
+
+			var gotAuthHeader string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuthHeader = r.Header.Get("Authorization")
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			route := config.Route{
+				Upstream:         upstream.URL,
+				TokenURL:         tokenServer.URL,
+				ClientID:         "client-id",
+				ClientSecret:     "client-secret",
+				Scope:            "read",
+				AuthMode:         tt.authMode,
+				SubjectField:     "subject",
+				ClientAuthMethod: config.ClientAuthSecretBasic,
+			}
+
+			srv, cleanup := newTestServer(t, route)
+			defer cleanup()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.subjectHeader != "" {
+				req.Header.Set("x-subject", tt.subjectHeader)
+			}
+			res := httptest.NewRecorder()
+
+			srv.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if tt.wantAuthHeader != "" && gotAuthHeader != tt.wantAuthHeader {
+				t.Errorf("Authorization = %q, want %q", gotAuthHeader, tt.wantAuthHeader)
+			}
+		})
+	}
+}
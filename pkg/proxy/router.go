@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"github.com/robbilie/oauth-client-credentials-proxy/logger"
+	"net/http"
+	"strings"
+)
+
+// RouteMatch selects which inbound requests a route's handler serves. A
+// RouteMatch with neither field set matches every request, i.e. it is the
+// catch-all/default route.
+type RouteMatch struct {
+	PathPrefix string
+	Host       string
+}
+
+func (m RouteMatch) matches(req *http.Request) bool {
+	if m.Host != "" && req.Host != m.Host {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, m.PathPrefix) {
+		return false
+	}
+	return true
+}
+
+type routeEntry struct {
+	match   RouteMatch
+	handler http.Handler
+}
+
+// Router dispatches each inbound request to the first route whose RouteMatch
+// matches, letting a single proxy instance front several upstreams with
+// distinct OAuth clients. Requests matching no route are rejected with 404.
+type Router struct {
+	logger logger.Logger
+	routes []routeEntry
+}
+
+func NewRouter(logger logger.Logger) *Router {
+	return &Router{logger: logger}
+}
+
+// Add registers handler for requests matching match, in the order routes
+// should be tried.
+func (r *Router) Add(match RouteMatch, handler http.Handler) {
+	r.routes = append(r.routes, routeEntry{match: match, handler: handler})
+}
+
+func (r *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	for _, entry := range r.routes {
+		if entry.match.matches(req) {
+			entry.handler.ServeHTTP(res, req)
+			return
+		}
+	}
+
+	r.logger.Errorw("No route matched request", "host", req.Host, "path", req.URL.Path)
+	requestsTotal.WithLabelValues("404").Inc()
+	res.WriteHeader(http.StatusNotFound)
+}
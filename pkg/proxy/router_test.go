@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		match      RouteMatch
+		path       string
+		host       string
+		wantStatus int
+	}{
+		{name: "path prefix match", match: RouteMatch{PathPrefix: "/a"}, path: "/a/b", wantStatus: http.StatusOK},
+		{name: "path prefix mismatch", match: RouteMatch{PathPrefix: "/a"}, path: "/b", wantStatus: http.StatusNotFound},
+		{name: "host match", match: RouteMatch{Host: "svc.internal"}, path: "/", host: "svc.internal", wantStatus: http.StatusOK},
+		{name: "host mismatch", match: RouteMatch{Host: "svc.internal"}, path: "/", host: "other.internal", wantStatus: http.StatusNotFound},
+		{name: "catch-all", match: RouteMatch{}, path: "/anything", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(testLogger{})
+			router.Add(tt.match, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			res := httptest.NewRecorder()
+
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+		})
+	}
+}